@@ -0,0 +1,254 @@
+package gphotos
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// BatcherOptions configures a Batcher.
+type BatcherOptions struct {
+	// MaxBatchSize caps how many items are sent in a single mediaItems:batchCreate
+	// call. Clamped to the API's own limit of 50 if unset or too large.
+	MaxBatchSize int
+	// BatchSizeAsync is the number of pending items, per album, that triggers an
+	// immediate flush. Defaults to MaxBatchSize.
+	BatchSizeAsync int
+	// TimeoutAsync is the longest a partially-filled batch waits, per album, before
+	// it is flushed anyway.
+	TimeoutAsync time.Duration
+	// TimeoutSync bounds how long Add's returned channel may take to fire before
+	// callers treat the batch as having stalled.
+	TimeoutSync time.Duration
+}
+
+// Result is what a Batcher reports back for a single item added with Add.
+type Result struct {
+	MediaItem *photoslibrary.MediaItem
+	Err       error
+}
+
+type batchItem struct {
+	newMediaItem *photoslibrary.NewMediaItem
+	result       chan Result
+	// syncTimeout fires Result{Err: ...} into result if the item hasn't been
+	// batched within BatcherOptions.TimeoutSync. Stopped once the real result
+	// is sent.
+	syncTimeout *time.Timer
+}
+
+// trySendResult delivers r to ch without blocking: ch is always buffered with
+// capacity 1, so this only fails (silently) when a result was already delivered,
+// e.g. a TimeoutSync firing after the batch itself already completed.
+func trySendResult(ch chan Result, r Result) {
+	select {
+	case ch <- r:
+	default:
+	}
+}
+
+// Batcher coalesces individual uploaded items into batched mediaItems:batchCreate
+// calls, grouped by album (batchCreate accepts only one album per call), instead of
+// issuing one batchCreate call per uploaded file. This dramatically reduces quota
+// consumption for bulk uploads.
+type Batcher struct {
+	client  *Client
+	options BatcherOptions
+
+	mu      sync.Mutex
+	pending map[string][]*batchItem // keyed by albumID
+	timers  map[string]*time.Timer
+
+	flush  chan string
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewBatcher constructs a Batcher bound to client, flushing per-album batches
+// according to options.
+func NewBatcher(client *Client, options BatcherOptions) *Batcher {
+	if options.MaxBatchSize <= 0 || options.MaxBatchSize > 50 {
+		options.MaxBatchSize = 50
+	}
+	if options.BatchSizeAsync <= 0 || options.BatchSizeAsync > options.MaxBatchSize {
+		options.BatchSizeAsync = options.MaxBatchSize
+	}
+	if options.TimeoutAsync <= 0 {
+		options.TimeoutAsync = 5 * time.Second
+	}
+	if options.TimeoutSync <= 0 {
+		options.TimeoutSync = 30 * time.Second
+	}
+
+	b := &Batcher{
+		client:  client,
+		options: options,
+		pending: map[string][]*batchItem{},
+		timers:  map[string]*time.Timer{},
+		flush:   make(chan string),
+		closed:  make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.loop()
+	return b
+}
+
+// Add enqueues an uploaded item for batching and returns a channel that receives
+// exactly one Result once the batch it ends up in has been created, or a timeout
+// Result if that takes longer than BatcherOptions.TimeoutSync.
+func (b *Batcher) Add(ctx context.Context, uploadToken string, newMediaItem *photoslibrary.NewMediaItem, albumID string) (<-chan Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if newMediaItem == nil {
+		newMediaItem = &photoslibrary.NewMediaItem{}
+	}
+	newMediaItem.SimpleMediaItem = &photoslibrary.SimpleMediaItem{UploadToken: uploadToken}
+
+	result := make(chan Result, 1)
+	item := &batchItem{newMediaItem: newMediaItem, result: result}
+	if b.options.TimeoutSync > 0 {
+		item.syncTimeout = time.AfterFunc(b.options.TimeoutSync, func() {
+			trySendResult(result, Result{Err: stacktrace.NewError("batch did not complete within TimeoutSync (%s)", b.options.TimeoutSync)})
+		})
+	}
+
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+		b.mu.Unlock()
+		if item.syncTimeout != nil {
+			item.syncTimeout.Stop()
+		}
+		return nil, stacktrace.NewError("batcher is closed")
+	default:
+	}
+
+	b.pending[albumID] = append(b.pending[albumID], item)
+	full := len(b.pending[albumID]) >= b.options.BatchSizeAsync
+	if !full && b.timers[albumID] == nil {
+		b.timers[albumID] = time.AfterFunc(b.options.TimeoutAsync, func() { b.requestFlush(albumID) })
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.requestFlush(albumID)
+	}
+	return result, nil
+}
+
+// requestFlush signals the background loop to flush albumID, giving up silently if
+// the Batcher is already closed (Close itself flushes everything pending).
+func (b *Batcher) requestFlush(albumID string) {
+	select {
+	case b.flush <- albumID:
+	case <-b.closed:
+	}
+}
+
+// loop owns the flush goroutine: it serializes flushes so only one batchCreate call
+// is in flight at a time. Add also writes pending/timers from callers' goroutines, so
+// every access to them holds mu.
+func (b *Batcher) loop() {
+	defer b.wg.Done()
+	for {
+		select {
+		case albumID := <-b.flush:
+			b.flushAlbum(albumID)
+		case <-b.closed:
+			b.mu.Lock()
+			albumIDs := make([]string, 0, len(b.pending))
+			for albumID := range b.pending {
+				albumIDs = append(albumIDs, albumID)
+			}
+			b.mu.Unlock()
+			for _, albumID := range albumIDs {
+				b.flushAlbum(albumID)
+			}
+			return
+		}
+	}
+}
+
+func (b *Batcher) flushAlbum(albumID string) {
+	b.mu.Lock()
+	items := b.pending[albumID]
+	delete(b.pending, albumID)
+	if t := b.timers[albumID]; t != nil {
+		t.Stop()
+		delete(b.timers, albumID)
+	}
+	b.mu.Unlock()
+
+	for len(items) > 0 {
+		n := b.options.MaxBatchSize
+		if n > len(items) {
+			n = len(items)
+		}
+		b.createBatch(albumID, items[:n])
+		items = items[n:]
+	}
+}
+
+// createBatch issues one mediaItems:batchCreate call and fans its response back out
+// to each item's result channel, by position in NewMediaItemResults.
+func (b *Batcher) createBatch(albumID string, items []*batchItem) {
+	newMediaItems := make([]*photoslibrary.NewMediaItem, len(items))
+	for i, item := range items {
+		newMediaItems[i] = item.newMediaItem
+	}
+
+	response, err := b.client.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		NewMediaItems: newMediaItems,
+	}).Do()
+	if err != nil {
+		err = stacktrace.Propagate(err, "batchCreate failed for album %q", albumID)
+		for _, item := range items {
+			item.deliver(Result{Err: err})
+		}
+		return
+	}
+
+	if len(response.NewMediaItemResults) != len(items) {
+		err := stacktrace.NewError("batchCreate returned %d results for %d items", len(response.NewMediaItemResults), len(items))
+		for _, item := range items {
+			item.deliver(Result{Err: err})
+		}
+		return
+	}
+
+	for i, item := range items {
+		result := response.NewMediaItemResults[i]
+		if result.Status == nil || result.Status.Message != "OK" {
+			message := "unknown error"
+			if result.Status != nil {
+				message = result.Status.Message
+			}
+			item.deliver(Result{Err: stacktrace.NewError("batchCreate item failed: %s", message)})
+			continue
+		}
+		item.deliver(Result{MediaItem: result.MediaItem})
+	}
+}
+
+// deliver stops the item's syncTimeout (if any) and sends r, dropping r instead of
+// blocking if a timeout result already beat it to the channel.
+func (item *batchItem) deliver(r Result) {
+	if item.syncTimeout != nil {
+		item.syncTimeout.Stop()
+	}
+	trySendResult(item.result, r)
+}
+
+// Close flushes any pending items and stops the Batcher's background goroutine. It
+// blocks until every pending item has been flushed.
+func (b *Batcher) Close() error {
+	close(b.closed)
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,101 @@
+package gphotos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/palantir/stacktrace"
+)
+
+// UploadCache lets UploadFile skip re-uploading a file whose contents it has already
+// seen, keyed by the hex-encoded SHA-256 of the file's bytes. The Photos API itself
+// has no dedupe, so repeated uploads of the same file across runs are the single
+// biggest avoidable cost for backup-style users.
+type UploadCache interface {
+	Lookup(sha256Hex string) (mediaItemID string, ok bool)
+	Store(sha256Hex string, mediaItemID string)
+}
+
+// sha256File hashes file's contents with one local read pass and leaves the file
+// positioned at the start, ready for the normal upload path on a cache miss.
+//
+// This is a deliberate two-pass read (hash, then upload) rather than a single
+// TeeReader over the upload body: whether to upload at all is exactly what the hash
+// decides, so the hash has to be known before the upload request is built, and a
+// TeeReader can't produce a hash before the reader it wraps has been read. The extra
+// local disk pass this costs on a cache miss is paid back by every cache hit, which
+// skips the upload entirely; callers uploading very large, mostly-new files and who
+// want to avoid the doubled local I/O should leave UploadCache unset.
+func sha256File(file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// jsonFileUploadCache is the built-in UploadCache: a single JSON file mapping
+// sha256Hex to mediaItemID, rewritten in full on every Store.
+type jsonFileUploadCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+// NewJSONFileUploadCache loads (or creates) an UploadCache backed by the JSON file at
+// path.
+func NewJSONFileUploadCache(path string) (UploadCache, error) {
+	cache := &jsonFileUploadCache{path: path, entries: map[string]string{}}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return cache, nil
+	case err != nil:
+		return nil, stacktrace.Propagate(err, "failed reading upload cache %s", path)
+	}
+	if len(b) == 0 {
+		return cache, nil
+	}
+	if err := json.Unmarshal(b, &cache.entries); err != nil {
+		return nil, stacktrace.Propagate(err, "failed parsing upload cache %s", path)
+	}
+	return cache, nil
+}
+
+func (c *jsonFileUploadCache) Lookup(sha256Hex string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mediaItemID, ok := c.entries[sha256Hex]
+	return mediaItemID, ok
+}
+
+func (c *jsonFileUploadCache) Store(sha256Hex string, mediaItemID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[sha256Hex] = mediaItemID
+	if err := c.save(); err != nil {
+		log.Printf("failed persisting upload cache %s: %v", c.path, err)
+	}
+}
+
+func (c *jsonFileUploadCache) save() error {
+	b, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, b, 0o600)
+}
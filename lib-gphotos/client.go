@@ -1,8 +1,9 @@
 package gphotos
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -27,6 +28,14 @@ type Client struct {
 	*photoslibrary.Service
 	*http.Client
 	token *oauth2.Token
+
+	uploadSessionStore UploadSessionStore
+	chunkSize          int64
+	batcher            *Batcher
+	uploadCache        UploadCache
+
+	maxAttempts int
+	pacer       Pacer
 }
 
 func parse429Header(header http.Header) int64 {
@@ -37,47 +46,46 @@ func parse429Header(header http.Header) int64 {
 	return after
 }
 
-func retry(attempts int, sleep time.Duration, fn func() error) error {
+// doWithRetry calls fn repeatedly until it succeeds, fn returns a non-retryable
+// error, client.maxAttempts is exhausted, or ctx is done. Between attempts it sleeps
+// for whatever the server's Retry-After asked for, falling back to client.pacer, and
+// that sleep itself is cut short if ctx is cancelled.
+func (client *Client) doWithRetry(ctx context.Context, fn func() error) error {
 	var err error
-	for ; attempts > 0; attempts-- {
+	for attempt := 0; attempt < client.maxAttempts; attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
 		err = fn()
-		if err != nil {
-			switch err.(type) {
-			case stopStatus:
-				// fn() returned critical stop
-				return err.(stopStatus).error
-			case retryStatus:
-				errRetry := err.(retryStatus)
-				if errRetry.retryAfter != 0 {
-					time.Sleep(time.Duration(errRetry.retryAfter) * time.Second)
-				} else {
-					time.Sleep(sleep)
-				}
-				// exponential backoff
-				sleep *= 2
-				continue
-			default:
-				// fn() returned unknown err
+		if err == nil {
+			client.pacer.Reset()
+			return nil
+		}
+
+		retryable, retryAfter := shouldRetry(err)
+		if !retryable {
+			return err
+		}
+
+		sleep := retryAfter
+		if sleep == 0 {
+			var again bool
+			sleep, again = client.pacer.Pause()
+			if !again {
 				return err
 			}
 		}
-		// fn() was ok return nil no retry
-		return nil
+		log.Printf("retryable error, sleeping %s before next attempt: %v", sleep, err)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	// return the final error
 	return err
 }
 
-type stopStatus struct {
-	error
-}
-
-type retryStatus struct {
-	error
-	// retry after seconds
-	retryAfter int
-}
-
 // Token returns the value of the token used by the gphotos Client
 // Cannot be used to set the token
 func (c *Client) Token() *oauth2.Token {
@@ -102,51 +110,101 @@ func NewClient(oauthHTTPClient *http.Client, maybeToken ...*oauth2.Token) (*Clie
 	if err != nil {
 		return nil, err
 	}
-	return &Client{photosService, oauthHTTPClient, token}, nil
+	return &Client{
+		Service:            photosService,
+		Client:             oauthHTTPClient,
+		token:              token,
+		uploadSessionStore: newMemoryUploadSessionStore(),
+		chunkSize:          DefaultChunkSize,
+		maxAttempts:        defaultMaxAttempts,
+		pacer:              newDefaultPacer(0, 0),
+	}, nil
+}
+
+// WithClientOptions applies opts to client, configuring how its network operations
+// are retried. Any zero-valued field in opts leaves the corresponding setting
+// unchanged.
+func (client *Client) WithClientOptions(opts ClientOptions) *Client {
+	if opts.MaxAttempts > 0 {
+		client.maxAttempts = opts.MaxAttempts
+	}
+	switch {
+	case opts.Pacer != nil:
+		client.pacer = opts.Pacer
+	case opts.MinSleep > 0 || opts.MaxSleep > 0:
+		client.pacer = newDefaultPacer(opts.MinSleep, opts.MaxSleep)
+	}
+	return client
+}
+
+// WithUploadSessionStore configures the UploadSessionStore used by UploadFileResumable to
+// persist upload progress. When unset, an in-memory store is used and resumable sessions
+// do not survive a process restart.
+func (client *Client) WithUploadSessionStore(store UploadSessionStore) *Client {
+	client.uploadSessionStore = store
+	return client
+}
+
+// WithChunkSize configures the chunk size, in bytes, used by UploadFileResumable.
+func (client *Client) WithChunkSize(bytes int64) *Client {
+	client.chunkSize = bytes
+	return client
+}
+
+// WithBatcher configures a Batcher for UploadFile to enqueue into instead of calling
+// MediaItems.BatchCreate directly, coalescing uploads across calls into fewer,
+// larger batchCreate requests.
+func (client *Client) WithBatcher(batcher *Batcher) *Client {
+	client.batcher = batcher
+	return client
+}
+
+// WithUploadCache configures an UploadCache consulted by UploadFile before uploading,
+// so a file whose contents were already uploaded in a previous call (or a previous
+// run of the process) is skipped entirely.
+func (client *Client) WithUploadCache(cache UploadCache) *Client {
+	client.uploadCache = cache
+	return client
 }
 
 // GetUploadToken sends the media and returns the UploadToken.
 func (client *Client) GetUploadToken(r *os.File, filename string) (token string, err error) {
-	// NoopCloser prevents body from closing so we can retry
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s%s/uploads", basePath, apiVersion), ioutil.NopCloser(r))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Add("X-Goog-Upload-File-Name", filename)
-	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	return client.GetUploadTokenContext(context.Background(), r, filename)
+}
 
-	// start retry
+// GetUploadTokenContext is GetUploadToken with a caller-provided context. ctx bounds
+// the request (and any retries) and is honored while waiting between retry attempts.
+func (client *Client) GetUploadTokenContext(ctx context.Context, r *os.File, filename string) (token string, err error) {
 	var res *http.Response
-	retryErr := retry(3, 1, func() error {
-		r.Seek(0, 0)
+	retryErr := client.doWithRetry(ctx, func() error {
+		// NoopCloser prevents body from closing so we can retry
+		req, reqErr := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s%s/uploads", basePath, apiVersion), ioutil.NopCloser(r))
+		if reqErr != nil {
+			return reqErr
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Add("X-Goog-Upload-File-Name", filename)
+		req.Header.Set("X-Goog-Upload-Protocol", "raw")
+
+		if _, seekErr := r.Seek(0, 0); seekErr != nil {
+			return seekErr
+		}
 		res, err = client.Client.Do(req)
 		if err != nil {
-			// internal error just stop
-			return stopStatus{error: err}
-		}
-		if res == nil {
-			return stopStatus{error: errors.New("empty response")}
+			return err
 		}
 		if res.StatusCode != 200 {
-			switch res.StatusCode {
-			case 429:
-				after := parse429Header(res.Header)
-				log.Printf("429 throttle waiting %d sec", after)
-				return retryStatus{retryAfter: int(after)}
-
-			default:
-				// for now we'll just quit. in future we can retry other errors
-				return nil
-			}
+			// Drain and close so a retried attempt (the classifier retries 5xx/429
+			// up to maxAttempts times) doesn't leak the connection back to the pool.
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			return &googleapi.Error{Code: res.StatusCode, Header: res.Header}
 		}
-		// we're ok res will have response body
 		return nil
 	})
 	if retryErr != nil {
 		return "", retryErr
 	}
-	// end retry
 	defer res.Body.Close()
 	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -157,6 +215,13 @@ func (client *Client) GetUploadToken(r *os.File, filename string) (token string,
 
 // Upload actually uploads the media and activates it on google photos
 func (client *Client) UploadFile(filePath string, pAlbumID ...string) (*photoslibrary.MediaItem, error) {
+	return client.UploadFileContext(context.Background(), filePath, pAlbumID...)
+}
+
+// UploadFileContext is UploadFile with a caller-provided context, cancelling the
+// upload token request, the batchCreate call (or batcher enqueue), and any retries
+// in between as soon as ctx is done.
+func (client *Client) UploadFileContext(ctx context.Context, filePath string, pAlbumID ...string) (*photoslibrary.MediaItem, error) {
 	// validate parameters
 	if len(pAlbumID) > 1 {
 		return nil, stacktrace.NewError("parameters can't include more than one albumID'")
@@ -175,14 +240,46 @@ func (client *Client) UploadFile(filePath string, pAlbumID ...string) (*photosli
 	}
 	defer file.Close()
 
-	uploadToken, err := client.GetUploadToken(file, filename)
+	var fileSum string
+	if client.uploadCache != nil {
+		fileSum, err = sha256File(file)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed hashing %s", filename)
+		}
+		if mediaItemID, ok := client.uploadCache.Lookup(fileSum); ok {
+			return client.adoptCachedMediaItem(ctx, mediaItemID, albumID, filename)
+		}
+	}
+
+	uploadToken, err := client.GetUploadTokenContext(ctx, file, filename)
 	if err != nil {
 		return nil, stacktrace.Propagate(err, "failed getting uploadToken for %s", filename)
 	}
 
+	if client.batcher != nil {
+		results, err := client.batcher.Add(ctx, uploadToken, &photoslibrary.NewMediaItem{Description: filename}, albumID)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed enqueueing %s on batcher", filename)
+		}
+		select {
+		case result := <-results:
+			if result.Err != nil {
+				return nil, stacktrace.Propagate(result.Err, "failed adding media %s", filename)
+			}
+			log.Printf("%s uploaded successfully as %s", filename, result.MediaItem.Id)
+			if client.uploadCache != nil {
+				client.uploadCache.Store(fileSum, result.MediaItem.Id)
+			}
+			return result.MediaItem, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	var batchResponse *photoslibrary.BatchCreateMediaItemsResponse
-	retryErr := retry(3, 1, func() error {
-		batchResponse, err = client.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+	retryErr := client.doWithRetry(ctx, func() error {
+		var batchErr error
+		batchResponse, batchErr = client.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
 			AlbumId: albumID,
 			NewMediaItems: []*photoslibrary.NewMediaItem{
 				&photoslibrary.NewMediaItem{
@@ -190,21 +287,11 @@ func (client *Client) UploadFile(filePath string, pAlbumID ...string) (*photosli
 					SimpleMediaItem: &photoslibrary.SimpleMediaItem{UploadToken: uploadToken},
 				},
 			},
-		}).Do()
-		if err != nil {
-			// handle rate limit error by sleeping and retrying
-			if err.(*googleapi.Error).Code == 429 {
-				after := parse429Header(err.(*googleapi.Error).Header)
-				log.Printf("Rate limit reached, sleeping for %d seconds...", after)
-				return retryStatus{retryAfter: int(after), error: err}
-			}
-			log.Printf("Unknown error uploading will retry")
-			return retryStatus{error: err}
-		}
-		return nil
+		}).Context(ctx).Do()
+		return batchErr
 	})
 	if retryErr != nil {
-		return nil, stacktrace.Propagate(err, "failed adding media %s", filename)
+		return nil, stacktrace.Propagate(retryErr, "failed adding media %s", filename)
 	}
 
 	if batchResponse == nil || len(batchResponse.NewMediaItemResults) != 1 {
@@ -216,23 +303,75 @@ func (client *Client) UploadFile(filePath string, pAlbumID ...string) (*photosli
 	}
 
 	log.Printf("%s uploaded successfully as %s", filename, result.MediaItem.Id)
+	if client.uploadCache != nil {
+		client.uploadCache.Store(fileSum, result.MediaItem.Id)
+	}
 	return result.MediaItem, nil
 }
 
-func (client *Client) AlbumByName(name string) (album *photoslibrary.Album, found bool, err error) {
-	listAlbumsResponse, err := client.Albums.List().Do()
+// adoptCachedMediaItem handles an UploadCache hit: the file was uploaded in a
+// previous call, so instead of uploading it again we just fetch the existing media
+// item and, when albumID is set, add it to albumID. Whether the item is already in
+// that album is not checked first.
+func (client *Client) adoptCachedMediaItem(ctx context.Context, mediaItemID, albumID, filename string) (*photoslibrary.MediaItem, error) {
+	mediaItem, err := client.MediaItems.Get(mediaItemID).Context(ctx).Do()
 	if err != nil {
-		return nil, false, stacktrace.Propagate(err, "failed listing albums")
+		return nil, stacktrace.Propagate(err, "failed getting cached media item %s", mediaItemID)
+	}
+	if albumID != "" {
+		_, err := client.Albums.BatchAddMediaItems(albumID, &photoslibrary.BatchAddMediaItemsRequest{
+			MediaItemIds: []string{mediaItemID},
+		}).Context(ctx).Do()
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed adding cached media item %s to album %s", mediaItemID, albumID)
+		}
 	}
-	for _, album := range listAlbumsResponse.Albums {
-		if album.Title == name {
-			return album, true, nil
+	log.Printf("%s already uploaded as %s, skipping upload", filename, mediaItemID)
+	return mediaItem, nil
+}
+
+// AlbumByName looks up an album by its title, paginating through every page of
+// albums.list since the album may not be on the first page.
+func (client *Client) AlbumByName(name string) (album *photoslibrary.Album, found bool, err error) {
+	return client.AlbumByNameContext(context.Background(), name)
+}
+
+// AlbumByNameContext is AlbumByName with a caller-provided context, consulted between
+// pages so a long-running lookup over many pages of albums can be cancelled.
+func (client *Client) AlbumByNameContext(ctx context.Context, name string) (album *photoslibrary.Album, found bool, err error) {
+	pageToken := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		call := client.Albums.List().PageSize(maxPageSize).Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
 		}
+		listAlbumsResponse, err := call.Do()
+		if err != nil {
+			return nil, false, stacktrace.Propagate(err, "failed listing albums")
+		}
+		for _, album := range listAlbumsResponse.Albums {
+			if album.Title == name {
+				return album, true, nil
+			}
+		}
+		if listAlbumsResponse.NextPageToken == "" {
+			break
+		}
+		pageToken = listAlbumsResponse.NextPageToken
 	}
 	return nil, false, nil
 }
 
 func (client *Client) GetOrCreateAlbumByName(albumName string) (*photoslibrary.Album, error) {
+	return client.GetOrCreateAlbumByNameContext(context.Background(), albumName)
+}
+
+// GetOrCreateAlbumByNameContext is GetOrCreateAlbumByName with a caller-provided
+// context.
+func (client *Client) GetOrCreateAlbumByNameContext(ctx context.Context, albumName string) (*photoslibrary.Album, error) {
 	// validate params
 	{
 		if albumName == "" {
@@ -241,12 +380,12 @@ func (client *Client) GetOrCreateAlbumByName(albumName string) (*photoslibrary.A
 	}
 
 	// try to find album by name
-	album, found, err := client.AlbumByName(albumName)
+	album, found, err := client.AlbumByNameContext(ctx, albumName)
 	if err != nil {
 		return nil, err
 	}
 	if found && album != nil {
-		return client.Albums.Get(album.Id).Do()
+		return client.Albums.Get(album.Id).Context(ctx).Do()
 	}
 
 	// else create album
@@ -254,7 +393,7 @@ func (client *Client) GetOrCreateAlbumByName(albumName string) (*photoslibrary.A
 		Album: &photoslibrary.Album{
 			Title: albumName,
 		},
-	}).Do()
+	}).Context(ctx).Do()
 }
 
 // func (client *Client) UpsertAlbum(album photoslibrary.Album) (*photoslibrary.Album, error) {
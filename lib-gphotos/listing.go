@@ -0,0 +1,169 @@
+package gphotos
+
+import (
+	"context"
+	"iter"
+
+	"github.com/palantir/stacktrace"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// maxPageSize is the largest pageSize the Photos API accepts for albums.list and
+// mediaItems:search.
+const maxPageSize = 50
+
+// ListAllAlbums returns every album owned by the user, paginating through
+// albums.list until all pages have been fetched. When includeShared is true,
+// albums shared with the user are appended as well.
+func (client *Client) ListAllAlbums(includeShared bool) ([]*photoslibrary.Album, error) {
+	return client.ListAllAlbumsContext(context.Background(), includeShared)
+}
+
+// ListAllAlbumsContext is ListAllAlbums with a caller-provided context, consulted
+// between pages.
+func (client *Client) ListAllAlbumsContext(ctx context.Context, includeShared bool) ([]*photoslibrary.Album, error) {
+	var albums []*photoslibrary.Album
+
+	pageToken := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		call := client.Albums.List().PageSize(maxPageSize).Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "failed listing albums")
+		}
+		albums = append(albums, resp.Albums...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	if includeShared {
+		pageToken = ""
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			call := client.SharedAlbums.List().PageSize(maxPageSize).Context(ctx)
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+			resp, err := call.Do()
+			if err != nil {
+				return nil, stacktrace.Propagate(err, "failed listing shared albums")
+			}
+			albums = append(albums, resp.SharedAlbums...)
+			if resp.NextPageToken == "" {
+				break
+			}
+			pageToken = resp.NextPageToken
+		}
+	}
+
+	return albums, nil
+}
+
+// SearchMediaItems walks every page of a mediaItems:search call matching filters,
+// yielding one (item, nil) pair per media item, or a single (nil, err) pair and
+// stops if a page request fails. Because the API can't express a date range
+// spanning multiple years in a single filter, a range that does is transparently
+// split into one request per calendar year and the results are concatenated.
+func (client *Client) SearchMediaItems(ctx context.Context, filters photoslibrary.Filters) iter.Seq2[*photoslibrary.MediaItem, error] {
+	return func(yield func(*photoslibrary.MediaItem, error) bool) {
+		for _, perYear := range expandDateFilter(filters) {
+			pageToken := ""
+			for {
+				select {
+				case <-ctx.Done():
+					yield(nil, ctx.Err())
+					return
+				default:
+				}
+
+				resp, err := client.MediaItems.Search(&photoslibrary.SearchMediaItemsRequest{
+					Filters:   &perYear,
+					PageSize:  maxPageSize,
+					PageToken: pageToken,
+				}).Context(ctx).Do()
+				if err != nil {
+					yield(nil, stacktrace.Propagate(err, "failed searching media items"))
+					return
+				}
+
+				for _, item := range resp.MediaItems {
+					if !yield(item, nil) {
+						return
+					}
+				}
+
+				if resp.NextPageToken == "" {
+					break
+				}
+				pageToken = resp.NextPageToken
+			}
+		}
+	}
+}
+
+// expandDateFilter splits filters.DateFilter's ranges into one Filters per calendar
+// year they span, since a single mediaItems:search request can't otherwise express a
+// multi-year range. Filters without a date range, or whose ranges all fall within a
+// single year, are returned unchanged.
+func expandDateFilter(filters photoslibrary.Filters) []photoslibrary.Filters {
+	if filters.DateFilter == nil || len(filters.DateFilter.Ranges) == 0 {
+		return []photoslibrary.Filters{filters}
+	}
+	multiYear := false
+	for _, r := range filters.DateFilter.Ranges {
+		if len(splitDateRangeByYear(r)) > 1 {
+			multiYear = true
+		}
+	}
+	if !multiYear {
+		return []photoslibrary.Filters{filters}
+	}
+
+	var expanded []photoslibrary.Filters
+	for _, r := range filters.DateFilter.Ranges {
+		for _, yearRange := range splitDateRangeByYear(r) {
+			f := filters
+			df := *filters.DateFilter
+			df.Ranges = []*photoslibrary.DateRange{yearRange}
+			// Single dates go in the first request only, so they aren't matched twice.
+			if len(expanded) > 0 {
+				df.Dates = nil
+			}
+			f.DateFilter = &df
+			expanded = append(expanded, f)
+		}
+	}
+	return expanded
+}
+
+// splitDateRangeByYear splits r into one DateRange per calendar year it spans.
+func splitDateRangeByYear(r *photoslibrary.DateRange) []*photoslibrary.DateRange {
+	if r.StartDate == nil || r.EndDate == nil || r.StartDate.Year == r.EndDate.Year {
+		return []*photoslibrary.DateRange{r}
+	}
+
+	var ranges []*photoslibrary.DateRange
+	for year := r.StartDate.Year; year <= r.EndDate.Year; year++ {
+		start := &photoslibrary.Date{Year: year, Month: 1, Day: 1}
+		end := &photoslibrary.Date{Year: year, Month: 12, Day: 31}
+		if year == r.StartDate.Year {
+			start = r.StartDate
+		}
+		if year == r.EndDate.Year {
+			end = r.EndDate
+		}
+		ranges = append(ranges, &photoslibrary.DateRange{StartDate: start, EndDate: end})
+	}
+	return ranges
+}
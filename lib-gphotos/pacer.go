@@ -0,0 +1,141 @@
+package gphotos
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// defaultMaxAttempts is how many attempts (including the first) client.doWithRetry
+// makes before giving up, when ClientOptions.MaxAttempts is unset.
+const defaultMaxAttempts = 5
+
+// Pacer decides how long to sleep between retries of a failing operation. A Client
+// shares a single Pacer across every retry loop, and those loops may run
+// concurrently, so implementations must be safe for concurrent use.
+type Pacer interface {
+	// Pause returns how long to sleep before the next attempt, and whether another
+	// attempt should be made at all.
+	Pause() (time.Duration, bool)
+	// Reset clears any accumulated backoff state. Called after a successful attempt.
+	Reset()
+}
+
+// ClientOptions configures a Client's retry behavior. Pass it to
+// Client.WithClientOptions.
+type ClientOptions struct {
+	// MaxAttempts is the maximum number of attempts, including the first, made
+	// before giving up on a retryable error. Defaults to 5.
+	MaxAttempts int
+	// MinSleep and MaxSleep bound the default Pacer's backoff. Ignored if Pacer
+	// is set.
+	MinSleep time.Duration
+	MaxSleep time.Duration
+	// Pacer, if set, overrides the default exponential-backoff-with-jitter pacer.
+	Pacer Pacer
+}
+
+const (
+	defaultMinSleep = 500 * time.Millisecond
+	defaultMaxSleep = 20 * time.Second
+)
+
+// defaultPacer implements exponential backoff with decorrelated jitter:
+// sleep = min(maxSleep, random_between(minSleep, prevSleep*3)).
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+//
+// A single Client shares one defaultPacer across every retry loop, and concurrent
+// UploadFileContext/GetUploadTokenContext calls each drive that loop from their own
+// goroutine, so both prevSleep and rand (not itself goroutine-safe) are guarded by mu.
+type defaultPacer struct {
+	mu        sync.Mutex
+	minSleep  time.Duration
+	maxSleep  time.Duration
+	prevSleep time.Duration
+	rand      *rand.Rand
+}
+
+func newDefaultPacer(minSleep, maxSleep time.Duration) *defaultPacer {
+	if minSleep <= 0 {
+		minSleep = defaultMinSleep
+	}
+	if maxSleep <= 0 {
+		maxSleep = defaultMaxSleep
+	}
+	return &defaultPacer{
+		minSleep:  minSleep,
+		maxSleep:  maxSleep,
+		prevSleep: minSleep,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *defaultPacer) Pause() (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	upper := p.prevSleep * 3
+	if upper <= p.minSleep {
+		upper = p.minSleep + 1
+	}
+	sleep := p.minSleep + time.Duration(p.rand.Int63n(int64(upper-p.minSleep)))
+	if sleep > p.maxSleep {
+		sleep = p.maxSleep
+	}
+	p.prevSleep = sleep
+	return sleep, true
+}
+
+func (p *defaultPacer) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prevSleep = p.minSleep
+}
+
+// temporary is satisfied by the (deprecated but still widely implemented) net.Error
+// methods used to detect transient network failures.
+type temporary interface {
+	Temporary() bool
+}
+
+// shouldRetry classifies err as retryable or not: a timed-out or temporary
+// net.Error, a 5xx or 429 googleapi.Error, or one of Google's rate-limit reason
+// strings are all worth retrying. retryAfter is non-zero when the server told us
+// exactly how long to wait.
+func shouldRetry(err error) (retry bool, retryAfter time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return true, 0
+		}
+		if t, ok := error(netErr).(temporary); ok && t.Temporary() {
+			return true, 0
+		}
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == 429 {
+			return true, time.Duration(parse429Header(apiErr.Header)) * time.Second
+		}
+		if apiErr.Code >= 500 && apiErr.Code < 600 {
+			return true, 0
+		}
+		for _, e := range apiErr.Errors {
+			switch e.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded":
+				return true, 0
+			}
+		}
+	}
+
+	return false, 0
+}
@@ -0,0 +1,267 @@
+package gphotos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// DefaultChunkSize is the chunk size used by UploadFileResumable when none is
+// configured via Client.WithChunkSize.
+const DefaultChunkSize int64 = 16 << 20 // 16 MiB
+
+// UploadSession is the state needed to resume an interrupted resumable upload.
+type UploadSession struct {
+	// UploadURL is the session URL returned by the initial "start" request.
+	UploadURL string
+	// Offset is the number of bytes known to have been received by the server.
+	Offset int64
+}
+
+// UploadSessionStore persists UploadSession state so a resumable upload can survive a
+// process restart. Implementations are keyed by an arbitrary string, typically built
+// with UploadSessionKey from the file's path, size and modification time.
+type UploadSessionStore interface {
+	Get(key string) (session UploadSession, found bool, err error)
+	Put(key string, session UploadSession) error
+	Delete(key string) error
+}
+
+// UploadSessionKey derives a stable UploadSessionStore key from a file's path, size and
+// modification time, so a resumed process recognizes an upload it started earlier.
+func UploadSessionKey(path string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", path, size, modTime.UnixNano())
+}
+
+// memoryUploadSessionStore is the default UploadSessionStore: it keeps sessions in
+// memory only, so they do not survive a process restart.
+type memoryUploadSessionStore struct {
+	sessions map[string]UploadSession
+}
+
+func newMemoryUploadSessionStore() *memoryUploadSessionStore {
+	return &memoryUploadSessionStore{sessions: map[string]UploadSession{}}
+}
+
+func (s *memoryUploadSessionStore) Get(key string) (UploadSession, bool, error) {
+	session, found := s.sessions[key]
+	return session, found, nil
+}
+
+func (s *memoryUploadSessionStore) Put(key string, session UploadSession) error {
+	s.sessions[key] = session
+	return nil
+}
+
+func (s *memoryUploadSessionStore) Delete(key string) error {
+	delete(s.sessions, key)
+	return nil
+}
+
+// startResumableSession sends the initial "start" request and returns the upload URL
+// the server wants subsequent chunks sent to.
+func (client *Client) startResumableSession(ctx context.Context, filename string, size int64) (uploadURL string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s%s/uploads", basePath, apiVersion), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Goog-Upload-Command", "start")
+	req.Header.Set("X-Goog-Upload-Protocol", "resumable")
+	req.Header.Set("X-Goog-Upload-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-Upload-Raw-Size", strconv.FormatInt(size, 10))
+	req.Header.Add("X-Goog-Upload-File-Name", filename)
+
+	res, err := client.Client.Do(req)
+	if err != nil {
+		return "", stacktrace.Propagate(err, "failed starting resumable upload session")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", stacktrace.NewError("failed starting resumable upload session: status %d", res.StatusCode)
+	}
+	uploadURL = res.Header.Get("X-Goog-Upload-URL")
+	if uploadURL == "" {
+		return "", stacktrace.NewError("server did not return an X-Goog-Upload-URL")
+	}
+	return uploadURL, nil
+}
+
+// queryResumableSession asks the server how many bytes of an in-progress resumable
+// upload it has actually received, so an interrupted upload can resume from the right
+// offset instead of from wherever the client last thought it was.
+func (client *Client) queryResumableSession(ctx context.Context, uploadURL string) (status string, bytesReceived int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("X-Goog-Upload-Command", "query")
+
+	res, err := client.Client.Do(req)
+	if err != nil {
+		return "", 0, stacktrace.Propagate(err, "failed querying resumable upload session")
+	}
+	defer res.Body.Close()
+	status = res.Header.Get("X-Goog-Upload-Status")
+	bytesReceived, _ = strconv.ParseInt(res.Header.Get("X-Goog-Upload-Size-Received"), 10, 64)
+	return status, bytesReceived, nil
+}
+
+// UploadFileResumable uploads a file using Google Photos' resumable upload protocol,
+// in chunks of client.chunkSize (see Client.WithChunkSize), resuming from the last
+// acknowledged offset on network failure or a 5xx response. Progress is persisted in
+// the configured UploadSessionStore (see Client.WithUploadSessionStore) so the upload
+// can resume even across a process restart. The returned token is consumed by
+// MediaItems.BatchCreate, same as the token returned by GetUploadToken.
+func (client *Client) UploadFileResumable(filePath string) (token string, err error) {
+	return client.UploadFileResumableContext(context.Background(), filePath)
+}
+
+// UploadFileResumableContext is UploadFileResumable with a caller-provided context.
+// ctx is checked before every chunk and every backoff sleep, so an in-flight chunked
+// upload stops promptly on cancellation instead of running to completion or to its
+// next failure.
+func (client *Client) UploadFileResumableContext(ctx context.Context, filePath string) (token string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", stacktrace.Propagate(err, "failed opening file")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", stacktrace.Propagate(err, "failed stating file")
+	}
+	filename := info.Name()
+	size := info.Size()
+	key := UploadSessionKey(filePath, size, info.ModTime())
+
+	session, found, err := client.uploadSessionStore.Get(key)
+	if err != nil {
+		return "", stacktrace.Propagate(err, "failed reading upload session")
+	}
+	if !found {
+		uploadURL, err := client.startResumableSession(ctx, filename, size)
+		if err != nil {
+			return "", err
+		}
+		session = UploadSession{UploadURL: uploadURL, Offset: 0}
+		if err := client.uploadSessionStore.Put(key, session); err != nil {
+			return "", stacktrace.Propagate(err, "failed persisting upload session")
+		}
+	}
+
+	// maxConsecutiveFailures bounds retries of the *same* chunk; it does not bound
+	// the number of chunks a large file needs, which a single shared counter would
+	// wrongly do.
+	const maxConsecutiveFailures = 5
+	consecutiveFailures := 0
+	sleep := time.Second
+	for session.Offset < size {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		chunkSize := client.chunkSize
+		if chunkSize <= 0 {
+			chunkSize = DefaultChunkSize
+		}
+		remaining := size - session.Offset
+		if chunkSize > remaining {
+			chunkSize = remaining
+		}
+		final := session.Offset+chunkSize >= size
+
+		if _, err := file.Seek(session.Offset, io.SeekStart); err != nil {
+			return "", stacktrace.Propagate(err, "failed seeking to offset %d", session.Offset)
+		}
+		body := io.LimitReader(file, chunkSize)
+
+		req, err := http.NewRequestWithContext(ctx, "POST", session.UploadURL, ioutil.NopCloser(body))
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = chunkSize
+		req.Header.Set("X-Goog-Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		if final {
+			req.Header.Set("X-Goog-Upload-Command", "upload, finalize")
+		} else {
+			req.Header.Set("X-Goog-Upload-Command", "upload")
+		}
+
+		res, doErr := client.Client.Do(req)
+		// Any non-200 - not just 5xx - means the server didn't accept the chunk:
+		// a 429 throttle or a 401 from a token that expired mid-upload are both
+		// plausible during a long multi-chunk upload, and treating them as
+		// success would advance session.Offset past bytes the server never
+		// received, corrupting the byte accounting for every chunk after it.
+		if doErr != nil || res == nil || res.StatusCode != 200 {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+			consecutiveFailures++
+			if consecutiveFailures >= maxConsecutiveFailures {
+				return "", stacktrace.NewError("failed uploading %s after %d consecutive failures", filename, consecutiveFailures)
+			}
+			retryAfter := sleep
+			if doErr != nil {
+				log.Printf("resumable upload chunk failed: %v, querying session to resume", doErr)
+			} else {
+				log.Printf("resumable upload chunk failed with status %d, querying session to resume", res.StatusCode)
+				if res.StatusCode == 429 {
+					if after := parse429Header(res.Header); after > 0 {
+						retryAfter = time.Duration(after) * time.Second
+					}
+				}
+				io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}
+			status, bytesReceived, queryErr := client.queryResumableSession(ctx, session.UploadURL)
+			if queryErr != nil {
+				return "", queryErr
+			}
+			if status == "cancelled" {
+				client.uploadSessionStore.Delete(key)
+				return "", stacktrace.NewError("upload session was cancelled by the server")
+			}
+			session.Offset = bytesReceived
+			client.uploadSessionStore.Put(key, session)
+			select {
+			case <-time.After(retryAfter):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			sleep *= 2
+			continue
+		}
+		consecutiveFailures = 0
+		sleep = time.Second
+
+		if !final {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			session.Offset += chunkSize
+			if err := client.uploadSessionStore.Put(key, session); err != nil {
+				return "", stacktrace.Propagate(err, "failed persisting upload session")
+			}
+			continue
+		}
+
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+		client.uploadSessionStore.Delete(key)
+		return string(b), nil
+	}
+
+	return "", stacktrace.NewError("failed uploading %s: offset %d never reached size %d", filename, session.Offset, size)
+}